@@ -55,18 +55,25 @@ func (f bloomFilter) Contains(filter, key []byte) bool {
 }
 
 func (f bloomFilter) NewGenerator() FilterGenerator {
+	return &bloomFilterGenerator{
+		n: int(f),
+		k: bloomNumProbes(int(f)),
+	}
+}
+
+// bloomNumProbes returns the number of hash probes (k) used per key for a
+// bloom filter with the given bitsPerKey, clamped to the [1, 30] range that
+// bloomFilter.Contains treats as a real, decodable k.
+func bloomNumProbes(bitsPerKey int) uint8 {
 	// Round down to reduce probing cost a little bit.
-	k := uint8(f * 69 / 100) // 0.69 =~ ln(2)  // ln2(f)
+	k := uint8(bitsPerKey * 69 / 100) // 0.69 =~ ln(2)  // ln2(f)
 	// 必须在[1, 30]之间
 	if k < 1 {
 		k = 1
 	} else if k > 30 {
 		k = 30
 	}
-	return &bloomFilterGenerator{
-		n: int(f),
-		k: k,
-	}
+	return k
 }
 
 type bloomFilterGenerator struct {
@@ -0,0 +1,142 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// ErrFilterMismatch is returned by BatchedBloomIndex.Add when a filter was
+// not generated with the same bitsPerKey/k parameters as the index.
+var ErrFilterMismatch = errors.New("leveldb/filter: filter parameters do not match batch index")
+
+// wordBits is the width, in bits, of a single column word.
+const wordBits = 64
+
+// BatchedBloomIndex groups a batch of previously-generated bloomFilter
+// outputs that all share the same k and nBits, and lets a caller test a key
+// against the whole batch with far fewer memory accesses than calling
+// Contains once per filter.
+//
+// Internally the batch is transposed: instead of storing each filter as a
+// row of nBits bits, it stores nBits columns of B bits, where column c's
+// bit i is set iff filter i has bit c set. Probing a key only touches the k
+// columns selected by bloomHash, ANDing them together one uint64 word at a
+// time, rather than touching k bits inside each of the B filters.
+type BatchedBloomIndex struct {
+	k     uint8
+	nBits uint32
+
+	// columns[c] holds the bits of column c, packed LSB-first across words.
+	columns [][]uint64
+
+	// n is the number of filters added so far.
+	n int
+}
+
+// NewBatchedBloomIndex creates an empty batch index for filters generated
+// with the given bitsPerKey. k, the number of hash probes per key, is
+// derived the same way bloomFilter.NewGenerator derives it, so it always
+// matches the filters NewBloomFilter(bitsPerKey) produces; it must match
+// the k recorded in every filter added to the batch.
+func NewBatchedBloomIndex(bitsPerKey int) *BatchedBloomIndex {
+	return &BatchedBloomIndex{
+		k: bloomNumProbes(bitsPerKey),
+	}
+}
+
+// Add appends filter, the serialized output of a bloomFilter generator, to
+// the batch. It returns ErrFilterMismatch if filter's encoded k or bit
+// length disagrees with the rest of the batch.
+func (idx *BatchedBloomIndex) Add(filter []byte) error {
+	nBytes := len(filter) - 1
+	if nBytes < 1 {
+		return ErrFilterMismatch
+	}
+	nBits := uint32(nBytes * 8)
+	k := filter[nBytes]
+	if k != idx.k {
+		return ErrFilterMismatch
+	}
+	if idx.n == 0 {
+		idx.nBits = nBits
+		idx.columns = make([][]uint64, nBits)
+	} else if nBits != idx.nBits {
+		return ErrFilterMismatch
+	}
+
+	i := idx.n
+	wordIdx, bitIdx := i/wordBits, uint(i%wordBits)
+	for c := uint32(0); c < nBits; c++ {
+		if wordIdx >= len(idx.columns[c]) {
+			idx.columns[c] = append(idx.columns[c], 0)
+		}
+		if (uint32(filter[c/8])&(1<<(c%8))) != 0 {
+			idx.columns[c][wordIdx] |= 1 << bitIdx
+		}
+	}
+	idx.n++
+	return nil
+}
+
+// Len returns the number of filters currently held in the batch.
+func (idx *BatchedBloomIndex) Len() int {
+	return idx.n
+}
+
+// MatchAll returns the indexes, in insertion order, of the filters in the
+// batch that may contain key. As with a single bloomFilter, false positives
+// are possible but false negatives are not.
+func (idx *BatchedBloomIndex) MatchAll(key []byte) []int {
+	if idx.n == 0 || idx.nBits == 0 {
+		return nil
+	}
+
+	nWords := (idx.n + wordBits - 1) / wordBits
+	acc := make([]uint64, nWords)
+	for i := range acc {
+		acc[i] = ^uint64(0)
+	}
+
+	kh := bloomHash(key)
+	delta := (kh >> 17) | (kh << 15)
+	for j := uint8(0); j < idx.k; j++ {
+		bitpos := kh % idx.nBits
+		col := idx.columns[bitpos]
+		for w := 0; w < nWords; w++ {
+			acc[w] &= col[w]
+		}
+		kh += delta
+	}
+
+	// Mask off the tail bits of the last word that don't correspond to a
+	// real filter, since they were never explicitly cleared on Add.
+	if tail := uint(idx.n % wordBits); tail != 0 {
+		acc[nWords-1] &= (uint64(1) << tail) - 1
+	}
+
+	var matches []int
+	for w := 0; w < nWords; w++ {
+		word := acc[w]
+		base := w * wordBits
+		for word != 0 {
+			matches = append(matches, base+bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+	return matches
+}
+
+// MatchKeys is a bulk form of MatchAll, returning one match slice per key.
+func (idx *BatchedBloomIndex) MatchKeys(keys [][]byte) [][]int {
+	out := make([][]int, len(keys))
+	for i, key := range keys {
+		out[i] = idx.MatchAll(key)
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+// StreamingBloomGenerator is an incremental variant of bloomFilterGenerator.
+// Instead of buffering every key's hash in a slice that grows without
+// bound until Generate is called, it commits each key's bits directly into
+// a caller-owned destination buffer as the key arrives. This keeps memory
+// use bounded by the filter's own size rather than by the number of keys
+// seen, which matters when building large sstables.
+//
+// Unlike bloomFilterGenerator, the filter's bit length must be known before
+// the first key is added, since bit positions are computed modulo it; call
+// Init with an estimate of the key count to size the destination buffer.
+type StreamingBloomGenerator struct {
+	n     int // bitsPerKey, i.e. the target m/n ratio
+	k     uint8
+	nBits uint32
+}
+
+// NewStreamingBloomGenerator returns a generator that sizes and probes its
+// filters exactly as bloomFilter.NewGenerator does for the same bitsPerKey.
+func NewStreamingBloomGenerator(bitsPerKey int) *StreamingBloomGenerator {
+	return &StreamingBloomGenerator{n: bitsPerKey, k: bloomNumProbes(bitsPerKey)}
+}
+
+// Init sizes a destination buffer for roughly estimatedKeys keys and
+// returns it, with its trailing k byte already filled in. The caller must
+// pass the returned slice to every subsequent AddInto call, and the
+// finished slice is a valid bloomFilter-encoded filter as-is.
+func (g *StreamingBloomGenerator) Init(estimatedKeys int) []byte {
+	nBits := uint32(estimatedKeys * g.n)
+	if nBits < 64 {
+		nBits = 64
+	}
+	nBytes := (nBits + 7) / 8
+	g.nBits = nBytes * 8
+
+	dest := make([]byte, nBytes+1)
+	dest[nBytes] = g.k
+	return dest
+}
+
+// AddInto commits key's bits into dest, which must be the slice returned by
+// the most recent call to Init on this generator, since bit positions are
+// taken modulo the bit length that call computed.
+func (g *StreamingBloomGenerator) AddInto(dest []byte, key []byte) {
+	kh := bloomHash(key)
+	delta := (kh >> 17) | (kh << 15) // Rotate right 17 bits
+	for j := uint8(0); j < g.k; j++ {
+		bitpos := kh % g.nBits
+		dest[bitpos/8] |= 1 << (bitpos % 8)
+		kh += delta
+	}
+}
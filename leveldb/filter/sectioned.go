@@ -0,0 +1,213 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// defaultSectionKeys is the number of keys covered by each bloom filter
+// section of a SectionedFilter, absent a more specific tuning.
+const defaultSectionKeys = 2048
+
+// SectionedFilter concatenates many small bloom filters, one per
+// fixed-size run of keys, plus an offset table keyed by each section's
+// last (largest) key. A lookup first finds the section whose key range
+// covers key, then runs the ordinary bloomFilter.Contains against just
+// that section, instead of consulting one filter covering every key in
+// the table. This lets range scans and point lookups skip whole key
+// regions whose section doesn't bound the key they're after.
+//
+// SectionedFilter registers under its own Name so it can coexist with
+// plain bloom-filter-encoded sstables; the Filter interface itself is
+// unchanged, so existing sstables keep loading as before.
+type SectionedFilter struct {
+	bitsPerKey     int
+	keysPerSection int
+}
+
+// NewSectionedFilter returns a SectionedFilter that generates bloom filter
+// sections at the given bitsPerKey, each covering up to keysPerSection
+// keys. A keysPerSection of 0 uses a built-in default.
+func NewSectionedFilter(bitsPerKey, keysPerSection int) *SectionedFilter {
+	if keysPerSection <= 0 {
+		keysPerSection = defaultSectionKeys
+	}
+	return &SectionedFilter{bitsPerKey: bitsPerKey, keysPerSection: keysPerSection}
+}
+
+// Name returns the filter's registered name; see Filter.Name.
+func (f *SectionedFilter) Name() string {
+	return "leveldb.SectionedBloomFilter"
+}
+
+// NewGenerator returns a new FilterGenerator accumulating sections.
+func (f *SectionedFilter) NewGenerator() FilterGenerator {
+	return &sectionedFilterGenerator{
+		bitsPerKey:     f.bitsPerKey,
+		keysPerSection: f.keysPerSection,
+	}
+}
+
+// Contains reports whether key may be a member of the set encoded into
+// filter, a SectionedFilter-encoded container.
+func (f *SectionedFilter) Contains(filter, key []byte) bool {
+	section, ok := findSection(filter, key)
+	if !ok {
+		// Malformed or foreign encoding: fail open, as bloomFilter.Contains
+		// does for a k it doesn't recognize.
+		return true
+	}
+	return bloomFilter(0).Contains(section, key)
+}
+
+// findSection locates the section of filter whose boundary key is the
+// first one greater than or equal to key, or the last section when key is
+// greater than every boundary, returning its serialized bloom filter bytes.
+func findSection(filter, key []byte) ([]byte, bool) {
+	n := len(filter)
+	if n < 8 {
+		return nil, false
+	}
+	indexOffset := int(binary.LittleEndian.Uint32(filter[n-8 : n-4]))
+	numSections := int(binary.LittleEndian.Uint32(filter[n-4:]))
+	if numSections == 0 || indexOffset < 0 || indexOffset > n-8 {
+		return nil, false
+	}
+
+	off := indexOffset
+	sectionStart := 0
+	for i := 0; i < numSections; i++ {
+		if off+6 > n-8 {
+			return nil, false
+		}
+		sectionEnd := int(binary.LittleEndian.Uint32(filter[off : off+4]))
+		off += 4
+		keyLen := int(binary.LittleEndian.Uint16(filter[off : off+2]))
+		off += 2
+		if off+keyLen > n-8 || sectionEnd < sectionStart || sectionEnd > n-8 {
+			return nil, false
+		}
+		boundary := filter[off : off+keyLen]
+		off += keyLen
+
+		if i == numSections-1 || bytes.Compare(key, boundary) <= 0 {
+			return filter[sectionStart:sectionEnd], true
+		}
+		sectionStart = sectionEnd
+	}
+	return nil, false
+}
+
+// sectionedFilterGenerator accumulates keys into fixed-size runs, closing
+// each into its own bloom filter section once keysPerSection keys have
+// been added.
+type sectionedFilterGenerator struct {
+	bitsPerKey     int
+	keysPerSection int
+
+	sections   [][]byte
+	boundaries [][]byte
+
+	cur     *bloomFilterGenerator
+	curKeys int
+	lastKey []byte
+}
+
+func (g *sectionedFilterGenerator) Add(key []byte) {
+	if g.cur == nil {
+		g.cur = &bloomFilterGenerator{n: g.bitsPerKey, k: bloomNumProbes(g.bitsPerKey)}
+	}
+	g.cur.Add(key)
+	g.curKeys++
+	g.lastKey = append(g.lastKey[:0], key...)
+	if g.curKeys >= g.keysPerSection {
+		g.closeSection()
+	}
+}
+
+// closeSection finalizes the bloom filter for the keys accumulated since
+// the last section closed, recording its boundary key.
+func (g *sectionedFilterGenerator) closeSection() {
+	if g.cur == nil || g.curKeys == 0 {
+		return
+	}
+	var buf sectionBuffer
+	g.cur.Generate(&buf)
+	g.sections = append(g.sections, buf.data)
+
+	boundary := make([]byte, len(g.lastKey))
+	copy(boundary, g.lastKey)
+	g.boundaries = append(g.boundaries, boundary)
+
+	g.cur = nil
+	g.curKeys = 0
+}
+
+// Generate lays out every closed section's filter bytes back to back,
+// followed by an index of (sectionEnd, boundaryKey) entries and a trailer
+// pointing at that index. See findSection for the matching decode.
+func (g *sectionedFilterGenerator) Generate(b Buffer) {
+	g.closeSection()
+
+	dataSize := 0
+	indexSize := 0
+	for i, s := range g.sections {
+		dataSize += len(s)
+		indexSize += 4 + 2 + len(g.boundaries[i])
+	}
+	const trailerSize = 8 // indexOffset(4) + numSections(4)
+
+	dest := b.Alloc(dataSize + indexSize + trailerSize)
+
+	off := 0
+	for _, s := range g.sections {
+		off += copy(dest[off:], s)
+	}
+
+	indexOffset := off
+	cum := 0
+	for i, s := range g.sections {
+		cum += len(s)
+		binary.LittleEndian.PutUint32(dest[off:off+4], uint32(cum))
+		off += 4
+		k := g.boundaries[i]
+		binary.LittleEndian.PutUint16(dest[off:off+2], uint16(len(k)))
+		off += 2
+		off += copy(dest[off:], k)
+	}
+
+	binary.LittleEndian.PutUint32(dest[off:off+4], uint32(indexOffset))
+	off += 4
+	binary.LittleEndian.PutUint32(dest[off:off+4], uint32(len(g.sections)))
+
+	g.sections = nil
+	g.boundaries = nil
+}
+
+// sectionBuffer is a minimal Buffer that hands a bloomFilterGenerator a
+// scratch slice to write into, so its output can be collected without
+// going through a full sstable-scale Buffer implementation.
+type sectionBuffer struct {
+	data []byte
+}
+
+func (b *sectionBuffer) Alloc(n int) []byte {
+	b.data = make([]byte, n)
+	return b.data
+}
+
+func (b *sectionBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *sectionBuffer) WriteByte(c byte) error {
+	b.data = append(b.data, c)
+	return nil
+}
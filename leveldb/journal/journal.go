@@ -81,6 +81,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
+
+	"github.com/golang/snappy"
 
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/storage"
@@ -88,13 +91,117 @@ import (
 )
 
 // These constants are part of the wire format and should not be changed.
+//
+// The compressed variants carry the same meaning as their raw counterpart
+// (full/first/middle/last chunk of a journal) but signal that the
+// concatenated payload of the journal was passed through the configured
+// Compression codec before being split into chunks.
 const (
 	fullChunkType   = 1
 	firstChunkType  = 2
 	middleChunkType = 3
 	lastChunkType   = 4
+
+	compressedFullChunkType   = 5
+	compressedFirstChunkType  = 6
+	compressedMiddleChunkType = 7
+	compressedLastChunkType   = 8
+
+	// The index* types frame the seekable footer written by
+	// Writer.writeFooter (see Options.Seekable). They never carry journal
+	// data; a reader that meets one while scanning forward for the start of
+	// the next journal has reached the clean end of the journal stream, not
+	// a corrupted chunk.
+	indexFullChunkType   = 9
+	indexFirstChunkType  = 10
+	indexMiddleChunkType = 11
+	indexLastChunkType   = 12
 )
 
+// CompressionType describes how a journal's per-journal payload is encoded
+// before being split into chunks.
+type CompressionType uint8
+
+const (
+	// NoCompression stores each journal's payload as-is, exactly as the
+	// original journal format always has.
+	NoCompression CompressionType = iota
+	// SnappyCompression runs the whole payload of a journal through Snappy
+	// before chunking it, when the payload is at least Options.MinCompressSize
+	// bytes long.
+	SnappyCompression
+)
+
+// Options customizes the wire format produced by a Writer. The zero value
+// matches the original, always-uncompressed journal format.
+type Options struct {
+	// Compression selects the codec used for a journal's payload. The
+	// default, NoCompression, reproduces the classic journal format.
+	Compression CompressionType
+	// MinCompressSize is the smallest payload, in bytes, that is worth
+	// compressing. Journals shorter than this are stored raw even when
+	// Compression is enabled, so small journals don't pay codec overhead.
+	MinCompressSize int
+	// Seekable makes the Writer record, for every journal it emits, the
+	// byte offset of its first chunk, and flush a footer index of that
+	// information on Close so a SeekableReader can jump straight to a
+	// given journal instead of scanning from the start of the stream.
+	Seekable bool
+}
+
+func isFullChunkType(t byte) bool   { return t == fullChunkType || t == compressedFullChunkType }
+func isFirstChunkType(t byte) bool  { return t == firstChunkType || t == compressedFirstChunkType }
+func isLastChunkType(t byte) bool   { return t == lastChunkType || t == compressedLastChunkType }
+func isCompressedChunkType(t byte) bool {
+	return t >= compressedFullChunkType && t <= compressedLastChunkType
+}
+func isIndexChunkType(t byte) bool {
+	return t >= indexFullChunkType && t <= indexLastChunkType
+}
+
+// indexChunkType picks the wire chunk type for a chunk of the footer index
+// that is (or isn't) its first and/or last chunk, mirroring chunkType.
+func indexChunkType(first, last bool) byte {
+	switch {
+	case first && last:
+		return indexFullChunkType
+	case first:
+		return indexFirstChunkType
+	case last:
+		return indexLastChunkType
+	default:
+		return indexMiddleChunkType
+	}
+}
+
+// chunkType picks the wire chunk type for a chunk that is (or isn't) the
+// first and/or last chunk of its journal, and that is (or isn't) carrying a
+// compressed payload.
+func chunkType(first, last, compressed bool) byte {
+	switch {
+	case first && last:
+		if compressed {
+			return compressedFullChunkType
+		}
+		return fullChunkType
+	case first:
+		if compressed {
+			return compressedFirstChunkType
+		}
+		return firstChunkType
+	case last:
+		if compressed {
+			return compressedLastChunkType
+		}
+		return lastChunkType
+	default:
+		if compressed {
+			return compressedMiddleChunkType
+		}
+		return middleChunkType
+	}
+}
+
 const (
 	blockSize  = 32 * 1024  // 一个block固定32KB
 	headerSize = 7  // header大小固定为7个字节
@@ -140,6 +247,9 @@ type Reader struct {
 	n int
 	// last is whether the current chunk is the last chunk of the journal.
 	last bool  // 是否为最后一个block
+	// compressed is whether the current chunk belongs to a journal whose
+	// payload was compressed before being split into chunks.
+	compressed bool
 	// err is any accumulated error.
 	err error
 	// buf is the buffer.
@@ -193,7 +303,20 @@ func (r *Reader) nextChunk(first bool) error {
 				r.j = r.n  // 无效的block，整个block drop掉
 				return r.corrupt(unprocBlock, "zero header", false)
 			}
-			if chunkType < fullChunkType || chunkType > lastChunkType {
+			if isIndexChunkType(chunkType) {
+				// The footer index isn't journal data: meeting it while
+				// looking for the next journal's first chunk is a clean end
+				// of stream, not corruption. Meeting it mid-journal means
+				// that journal's last chunk is missing.
+				r.i = r.n
+				r.j = r.n
+				if first {
+					r.err = io.EOF
+					return r.err
+				}
+				return r.corrupt(unprocBlock, "missing chunk part", false)
+			}
+			if chunkType < fullChunkType || chunkType > compressedLastChunkType {
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n  // 无效的chunkType
@@ -213,13 +336,14 @@ func (r *Reader) nextChunk(first bool) error {
 				r.j = r.n
 				return r.corrupt(unprocBlock, "checksum mismatch", false)
 			}
-			if first && chunkType != fullChunkType && chunkType != firstChunkType {
+			if first && !isFullChunkType(chunkType) && !isFirstChunkType(chunkType) {
 				chunkLength := (r.j - r.i) + headerSize
 				r.i = r.j
 				// Report the error, but skip it.
 				return r.corrupt(chunkLength, "orphan chunk", true)
 			}
-			r.last = chunkType == fullChunkType || chunkType == lastChunkType
+			r.last = isFullChunkType(chunkType) || isLastChunkType(chunkType)
+			r.compressed = isCompressedChunkType(chunkType)
 			return nil
 		}
 
@@ -266,7 +390,7 @@ func (r *Reader) Next() (io.Reader, error) {
 			return nil, err
 		}
 	}
-	return &singleReader{r, r.seq, nil}, nil
+	return &singleReader{r: r, seq: r.seq}, nil
 }
 
 // Reset resets the journal reader, allows reuse of the journal reader. Reset returns
@@ -286,10 +410,69 @@ func (r *Reader) Reset(reader io.Reader, dropper Dropper, strict, checksum bool)
 	return err
 }
 
+// resetForSeek primes the reader to resume the nextChunk state machine at
+// chunkOffset within the next block read from reader, which the caller must
+// have already positioned (via io.Seeker.Seek) at the start of that block.
+// It is used by SeekableReader.SeekToSeq to resume mid-stream instead of
+// scanning from the beginning.
+func (r *Reader) resetForSeek(reader io.Reader, chunkOffset int, dropper Dropper, strict, checksum bool) error {
+	r.r = reader
+	r.dropper = dropper
+	r.strict = strict
+	r.checksum = checksum
+	n, err := io.ReadFull(reader, r.buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	r.i, r.j, r.n = chunkOffset, chunkOffset, n
+	r.last = true
+	r.compressed = false
+	r.err = nil
+	return nil
+}
+
 type singleReader struct {
 	r   *Reader
 	seq int
 	err error
+
+	// decoded and decodedPos serve reads for a compressed journal, once
+	// loadCompressed has buffered and decompressed all of its chunks.
+	loaded     bool
+	decoded    []byte
+	decodedPos int
+}
+
+// loadCompressed concatenates every remaining chunk of the current journal
+// into a single buffer and decompresses it. It is only called for journals
+// whose first chunk was a compressed*ChunkType.
+func (x *singleReader) loadCompressed() error {
+	r := x.r
+	var raw []byte
+	for {
+		raw = append(raw, r.buf[r.i:r.j]...)
+		r.i = r.j
+		if r.last {
+			break
+		}
+		if err := r.nextChunk(false); err != nil {
+			if err == errSkip {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	decoded, err := snappy.Decode(nil, raw)
+	if err != nil {
+		err = r.corrupt(len(raw), "corrupted compressed payload", false)
+		if err == errSkip {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	x.decoded = decoded
+	x.loaded = true
+	return nil
 }
 
 func (x *singleReader) Read(p []byte) (int, error) {
@@ -303,6 +486,20 @@ func (x *singleReader) Read(p []byte) (int, error) {
 	if r.err != nil {
 		return 0, r.err
 	}
+	if r.compressed {
+		if !x.loaded {
+			if err := x.loadCompressed(); err != nil {
+				x.err = err
+				return 0, err
+			}
+		}
+		if x.decodedPos == len(x.decoded) {
+			return 0, io.EOF
+		}
+		n := copy(p, x.decoded[x.decodedPos:])
+		x.decodedPos += n
+		return n, nil
+	}
 	for r.i == r.j {
 		if r.last {
 			return 0, io.EOF
@@ -322,31 +519,16 @@ func (x *singleReader) Read(p []byte) (int, error) {
 }
 
 func (x *singleReader) ReadByte() (byte, error) {
-	r := x.r
-	if r.seq != x.seq {
-		return 0, errors.New("leveldb/journal: stale reader")
-	}
-	if x.err != nil {
-		return 0, x.err
-	}
-	if r.err != nil {
-		return 0, r.err
-	}
-	for r.i == r.j {
-		if r.last {
-			return 0, io.EOF
+	var b [1]byte
+	for {
+		n, err := x.Read(b[:])
+		if n == 1 {
+			return b[0], nil
 		}
-		x.err = r.nextChunk(false)
-		if x.err != nil {
-			if x.err == errSkip {
-				x.err = io.ErrUnexpectedEOF
-			}
-			return 0, x.err
+		if err != nil {
+			return 0, err
 		}
 	}
-	c := r.buf[r.i]  // 只读出一个字节
-	r.i++
-	return c, nil
 }
 
 // Writer writes journals to an underlying io.Writer.
@@ -372,14 +554,75 @@ type Writer struct {
 	err error
 	// buf is the buffer.
 	buf [blockSize]byte
+
+	// compression and minCompressSize mirror the Options a Writer was built
+	// with; see NewWriterWithOptions.
+	compression     CompressionType
+	minCompressSize int
+	// compressing is whether the journal currently being written is using
+	// the compressed chunk types.
+	compressing bool
+	// havePending is whether Next has handed out a singleWriter for the
+	// current journal that hasn't been committed to the block buffer yet.
+	// It is only used when compression is enabled, since in that mode a
+	// journal's bytes are buffered in payload until its full length is
+	// known instead of being chunked as they arrive.
+	havePending bool
+	// payload buffers the current journal's bytes when compression is
+	// enabled.
+	payload []byte
+
+	// seekable is whether a footer index is flushed on Close; see Options.
+	seekable bool
+	// blockStreamOffset is the absolute byte offset, in the underlying
+	// writer's stream, of the start of the block currently being filled
+	// into buf. It only advances when writeBlock flushes a full block.
+	blockStreamOffset int64
+	// journalCount is the number of journals started via Next so far; it
+	// doubles as the seq recorded in the seekable index.
+	journalCount int
+	// pendingSeq is the journalCount of the journal whose payload is
+	// currently buffered in payload, used to label its index entry once
+	// commitPendingPayload seals it.
+	pendingSeq int
+	// index accumulates one seekIndexEntry per journal when seekable.
+	index []seekIndexEntry
+	// writingIndex is whether the chunk currently being filled is part of
+	// the footer index written by writeFooter, rather than journal data;
+	// it makes fillHeader pick an index* chunk type instead of the usual
+	// full/first/middle/last one.
+	writingIndex bool
+}
+
+// seekIndexEntry locates one journal's first chunk: blockOffset is the
+// absolute byte offset of the block containing it, and chunkOffset is the
+// byte offset of the chunk header within that block.
+type seekIndexEntry struct {
+	seq         int
+	blockOffset int64
+	chunkOffset int
 }
 
-// NewWriter returns a new Writer.
+// NewWriter returns a new Writer that always stores journals uncompressed,
+// equivalent to NewWriterWithOptions(w, Options{}).
 func NewWriter(w io.Writer) *Writer {
+	return NewWriterWithOptions(w, Options{})
+}
+
+// NewWriterWithOptions returns a new Writer that applies o to every journal
+// it writes. With o.Compression set, a journal's payload is buffered until
+// Next, Close, Flush or Reset seals it, at which point it is compressed (if
+// at least o.MinCompressSize bytes) and split into compressed chunks;
+// shorter payloads fall back to the raw chunk types so tiny journals don't
+// pay codec overhead.
+func NewWriterWithOptions(w io.Writer, o Options) *Writer {
 	f, _ := w.(flusher)
 	return &Writer{
-		w: w,
-		f: f,
+		w:               w,
+		f:               f,
+		compression:     o.Compression,
+		minCompressSize: o.MinCompressSize,
+		seekable:        o.Seekable,
 	}
 }
 
@@ -388,18 +631,10 @@ func (w *Writer) fillHeader(last bool) {
 	if w.i+headerSize > w.j || w.j > blockSize {
 		panic("leveldb/journal: bad writer state")
 	}
-	if last {
-		if w.first {
-			w.buf[w.i+6] = fullChunkType
-		} else {
-			w.buf[w.i+6] = lastChunkType  // 最后一个包
-		}
+	if w.writingIndex {
+		w.buf[w.i+6] = indexChunkType(w.first, last)
 	} else {
-		if w.first {
-			w.buf[w.i+6] = firstChunkType
-		} else {
-			w.buf[w.i+6] = middleChunkType
-		}
+		w.buf[w.i+6] = chunkType(w.first, last, w.compressing)
 	}
 	binary.LittleEndian.PutUint32(w.buf[w.i+0:w.i+4], util.NewCRC(w.buf[w.i+6:w.j]).Value())
 	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-headerSize))
@@ -409,6 +644,7 @@ func (w *Writer) fillHeader(last bool) {
 // space for the next chunk's header.
 func (w *Writer) writeBlock() {
 	_, w.err = w.w.Write(w.buf[w.written:])  // w.buf[w.written]从内存里面写入到w.w.Write
+	w.blockStreamOffset += blockSize
 	w.i = 0
 	w.j = headerSize
 	w.written = 0
@@ -429,10 +665,156 @@ func (w *Writer) writePending() {
 	w.written = w.j
 }
 
+// reserveChunkHeader reserves the next headerSize bytes of buf for a chunk
+// header, flushing the current block first if there isn't room. It is the
+// bookkeeping Next used to do inline before every journal started.
+func (w *Writer) reserveChunkHeader() {
+	w.i = w.j
+	w.j = w.j + headerSize
+	if w.j > blockSize {
+		// Fill in the rest of the block with zeroes.
+		for k := w.i; k < blockSize; k++ {
+			w.buf[k] = 0
+		}
+		w.writeBlock()
+	}
+}
+
+// recordIndexEntry appends a seekable-index entry pointing at the chunk
+// currently reserved at w.i, labelling it with seq.
+func (w *Writer) recordIndexEntry(seq int) {
+	w.index = append(w.index, seekIndexEntry{
+		seq:         seq,
+		blockOffset: w.blockStreamOffset,
+		chunkOffset: w.i,
+	})
+}
+
+// commitPendingPayload seals the buffered payload of a compression-enabled
+// journal: it compresses the payload when it meets MinCompressSize, then
+// chunks it into the block buffer and immediately closes out the journal,
+// since (unlike the streaming, uncompressed path) the full payload length
+// is already known.
+func (w *Writer) commitPendingPayload() {
+	if w.err != nil || !w.havePending {
+		return
+	}
+	w.havePending = false
+
+	data := w.payload
+	w.payload = nil
+	w.compressing = w.compression != NoCompression && len(data) >= w.minCompressSize
+	if w.compressing {
+		data = snappy.Encode(nil, data)
+	}
+
+	w.reserveChunkHeader()
+	if w.err != nil {
+		return
+	}
+	if w.seekable {
+		w.recordIndexEntry(w.pendingSeq)
+	}
+	w.first = true
+	w.pending = true
+
+	p := data
+	for len(p) > 0 {
+		if w.j == blockSize {
+			w.fillHeader(false)
+			w.writeBlock()
+			if w.err != nil {
+				return
+			}
+			w.first = false
+		}
+		n := copy(w.buf[w.j:], p)
+		w.j += n
+		p = p[n:]
+	}
+	w.fillHeader(true)
+	w.pending = false
+}
+
+// seekFooterMagic identifies a trailing seekable footer; it has no meaning
+// beyond letting NewSeekableReader tell a seekable stream apart from one
+// written by a plain Writer.
+const seekFooterMagic = uint64(0x6c65766c644a726c)
+
+// seekFooterSize is the fixed size, in bytes, of the trailer written by
+// writeFooter: a magic, the index's first chunk's block offset, and its
+// chunk offset within that block.
+const seekFooterSize = 8 + 8 + 4
+
+// writeFooter chunks and flushes the accumulated seekable index using the
+// index* chunk types, exactly like a journal's payload, so a reader scanning
+// forward (Reader.Next, or SeekableReader's linear-scan fallback) recognizes
+// it and stops cleanly instead of reading raw bytes as a corrupted chunk.
+// It then appends a fixed-size trailer locating the index's first chunk, so
+// NewSeekableReader can seek straight to it from the end of the stream.
+func (w *Writer) writeFooter() {
+	if w.err != nil {
+		return
+	}
+
+	payload := make([]byte, 4+len(w.index)*16)
+	binary.LittleEndian.PutUint32(payload[0:4], uint32(len(w.index)))
+	off := 4
+	for _, e := range w.index {
+		binary.LittleEndian.PutUint32(payload[off:off+4], uint32(e.seq))
+		binary.LittleEndian.PutUint64(payload[off+4:off+12], uint64(e.blockOffset))
+		binary.LittleEndian.PutUint32(payload[off+12:off+16], uint32(e.chunkOffset))
+		off += 16
+	}
+
+	w.reserveChunkHeader()
+	if w.err != nil {
+		return
+	}
+	footerBlockOffset := w.blockStreamOffset
+	footerChunkOffset := w.i
+	w.writingIndex = true
+	w.first = true
+	w.pending = true
+
+	p := payload
+	for len(p) > 0 {
+		if w.j == blockSize {
+			w.fillHeader(false)
+			w.writeBlock()
+			if w.err != nil {
+				w.writingIndex = false
+				return
+			}
+			w.first = false
+		}
+		n := copy(w.buf[w.j:], p)
+		w.j += n
+		p = p[n:]
+	}
+	w.writePending()
+	w.writingIndex = false
+	if w.err != nil {
+		return
+	}
+
+	var trailer [seekFooterSize]byte
+	binary.LittleEndian.PutUint64(trailer[0:8], seekFooterMagic)
+	binary.LittleEndian.PutUint64(trailer[8:16], uint64(footerBlockOffset))
+	binary.LittleEndian.PutUint32(trailer[16:20], uint32(footerChunkOffset))
+	_, w.err = w.w.Write(trailer[:])
+}
+
 // Close finishes the current journal and closes the writer.
 func (w *Writer) Close() error {
 	w.seq++
+	if w.compression != NoCompression {
+		w.commitPendingPayload()
+	}
 	w.writePending()
+	if w.seekable {
+		w.writeFooter()
+	}
 	if w.err != nil {
 		return w.err
 	}
@@ -445,6 +827,9 @@ func (w *Writer) Close() error {
 // 把当前日志刷盘
 func (w *Writer) Flush() error {
 	w.seq++
+	if w.compression != NoCompression {
+		w.commitPendingPayload()
+	}
 	w.writePending()
 	if w.err != nil {
 		return w.err
@@ -461,7 +846,13 @@ func (w *Writer) Flush() error {
 func (w *Writer) Reset(writer io.Writer) (err error) {
 	w.seq++
 	if w.err == nil {
+		if w.compression != NoCompression {
+			w.commitPendingPayload()
+		}
 		w.writePending()
+		if w.seekable {
+			w.writeFooter()
+		}
 		err = w.err
 	}
 	w.w = writer
@@ -471,6 +862,14 @@ func (w *Writer) Reset(writer io.Writer) (err error) {
 	w.written = 0
 	w.first = false
 	w.pending = false
+	w.compressing = false
+	w.havePending = false
+	w.payload = nil
+	w.blockStreamOffset = 0
+	w.journalCount = 0
+	w.pendingSeq = 0
+	w.index = nil
+	w.writingIndex = false
 	w.err = nil
 	return
 }
@@ -483,24 +882,29 @@ func (w *Writer) Next() (io.Writer, error) {
 	if w.err != nil {
 		return nil, w.err
 	}
+	w.journalCount++
+	if w.compression != NoCompression {
+		// The previous journal's payload is buffered in w.payload; it isn't
+		// chunked until its full length is known, here or on Close/Flush.
+		w.commitPendingPayload()
+		if w.err != nil {
+			return nil, w.err
+		}
+		w.pendingSeq = w.journalCount
+		w.payload = w.payload[:0]
+		w.havePending = true
+		return singleWriter{w, w.seq}, nil
+	}
 	if w.pending {
 		w.fillHeader(true)
 	}
-	w.i = w.j
-	w.j = w.j + headerSize
-	// Check if there is room in the block for the header.
 	// 判断当前block是否有足够的空间写入一个header
-	if w.j > blockSize {
-		// Fill in the rest of the block with zeroes.
-		// 不够空间，直接将当前block的剩余空间置0
-		for k := w.i; k < blockSize; k++ {
-			w.buf[k] = 0
-		}
-		// 将已写入buf的信息写入文件
-		w.writeBlock()
-		if w.err != nil {
-			return nil, w.err
-		}
+	w.reserveChunkHeader()
+	if w.err != nil {
+		return nil, w.err
+	}
+	if w.seekable {
+		w.recordIndexEntry(w.journalCount)
 	}
 	w.first = true  // 是否开始写第一个trunk
 	w.pending = true  // 是否就绪
@@ -521,6 +925,12 @@ func (x singleWriter) Write(p []byte) (int, error) {
 	if w.err != nil {
 		return 0, w.err
 	}
+	if w.compression != NoCompression {
+		// Buffer the payload; it is compressed and chunked as a whole once
+		// this journal is sealed by the next Next, Close, Flush or Reset.
+		w.payload = append(w.payload, p...)
+		return len(p), nil
+	}
 	n0 := len(p)
 	for len(p) > 0 {
 		// Write a block, if it is full.  // 刚好写满一个block
@@ -540,3 +950,188 @@ func (x singleWriter) Write(p []byte) (int, error) {
 	}
 	return n0, nil
 }
+
+// SeekableReader reads journals written with Options{Seekable: true},
+// letting a caller jump directly to a given journal's sequence number
+// instead of scanning the whole stream from the start. Files written by a
+// plain (non-seekable) Writer are still readable: when no footer is found,
+// SeekableReader falls back to linear scanning via Next, and SeekToSeq and
+// NumJournals report that no random access is available.
+type SeekableReader struct {
+	r        io.ReadSeeker
+	dropper  Dropper
+	strict   bool
+	checksum bool
+
+	reader *Reader
+	index  []seekIndexEntry // sorted by seq; nil if no footer was found
+}
+
+// NewSeekableReader returns a new SeekableReader over r. The dropper,
+// strict and checksum parameters have the same meaning as in NewReader.
+func NewSeekableReader(r io.ReadSeeker, dropper Dropper, strict, checksum bool) (*SeekableReader, error) {
+	sr := &SeekableReader{
+		r:        r,
+		dropper:  dropper,
+		strict:   strict,
+		checksum: checksum,
+	}
+	index, err := readSeekFooter(r)
+	if err != nil {
+		return nil, err
+	}
+	sr.index = index
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sr.reader = NewReader(r, dropper, strict, checksum)
+	return sr, nil
+}
+
+// readIndexChunks reads and concatenates the payload of the index* chunk
+// sequence starting at chunkOffset within the block at blockOffset,
+// stopping at the first indexFullChunkType or indexLastChunkType chunk. It
+// reports ok false, without error, for anything that doesn't look like a
+// well-formed index chunk sequence, which readSeekFooter treats the same as
+// finding no footer at all.
+func readIndexChunks(r io.ReadSeeker, blockOffset int64, chunkOffset int) (buf []byte, ok bool, err error) {
+	if _, err := r.Seek(blockOffset, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	var block [blockSize]byte
+	n, err := io.ReadFull(r, block[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	i := chunkOffset
+	for {
+		if i+headerSize > n {
+			return nil, false, nil
+		}
+		checksum := binary.LittleEndian.Uint32(block[i+0 : i+4])
+		length := binary.LittleEndian.Uint16(block[i+4 : i+6])
+		ct := block[i+6]
+		if !isIndexChunkType(ct) {
+			return nil, false, nil
+		}
+		start := i + headerSize
+		end := start + int(length)
+		if end > n || checksum != util.NewCRC(block[i+6:end]).Value() {
+			return nil, false, nil
+		}
+		buf = append(buf, block[start:end]...)
+		if ct == indexFullChunkType || ct == indexLastChunkType {
+			return buf, true, nil
+		}
+
+		n, err = io.ReadFull(r, block[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, false, err
+		}
+		if n == 0 {
+			return nil, false, nil
+		}
+		i = 0
+	}
+}
+
+// readSeekFooter locates the trailing footer written by Writer.writeFooter
+// and, if found, reads the index it points at by walking the index* chunks
+// exactly as an ordinary journal is read. It returns a nil index, without
+// error, when r is shorter than a footer or its magic doesn't match, which
+// callers treat as "not a seekable stream".
+func readSeekFooter(r io.ReadSeeker) ([]seekIndexEntry, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < seekFooterSize {
+		return nil, nil
+	}
+	if _, err := r.Seek(size-seekFooterSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var trailer [seekFooterSize]byte
+	if _, err := io.ReadFull(r, trailer[:]); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(trailer[0:8]) != seekFooterMagic {
+		return nil, nil
+	}
+	blockOffset := int64(binary.LittleEndian.Uint64(trailer[8:16]))
+	chunkOffset := int(binary.LittleEndian.Uint32(trailer[16:20]))
+	if blockOffset < 0 || blockOffset > size-seekFooterSize || chunkOffset < 0 || chunkOffset >= blockSize {
+		return nil, nil
+	}
+
+	buf, ok, err := readIndexChunks(r, blockOffset, chunkOffset)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(buf) < 4 {
+		return nil, nil
+	}
+	count := binary.LittleEndian.Uint32(buf[0:4])
+	if int64(4+int(count)*16) != int64(len(buf)) {
+		return nil, nil
+	}
+	index := make([]seekIndexEntry, count)
+	off := 4
+	for i := range index {
+		index[i] = seekIndexEntry{
+			seq:         int(binary.LittleEndian.Uint32(buf[off : off+4])),
+			blockOffset: int64(binary.LittleEndian.Uint64(buf[off+4 : off+12])),
+			chunkOffset: int(binary.LittleEndian.Uint32(buf[off+12 : off+16])),
+		}
+		off += 16
+	}
+	return index, nil
+}
+
+// NumJournals returns the number of journals recorded in the footer index,
+// or -1 if the stream has no footer (either because it was written by a
+// non-seekable Writer, or because it doesn't end in one yet).
+func (sr *SeekableReader) NumJournals() int {
+	if sr.index == nil {
+		return -1
+	}
+	return len(sr.index)
+}
+
+// SeekToSeq returns a reader for the journal with the given sequence
+// number, seeking directly to its first chunk instead of scanning from the
+// start of the stream. It returns an error if the stream has no footer or
+// no journal with that seq was recorded.
+func (sr *SeekableReader) SeekToSeq(seq int) (io.Reader, error) {
+	if sr.index == nil {
+		return nil, errors.New("leveldb/journal: stream has no seekable index")
+	}
+	i := sort.Search(len(sr.index), func(i int) bool { return sr.index[i].seq >= seq })
+	if i >= len(sr.index) || sr.index[i].seq != seq {
+		return nil, fmt.Errorf("leveldb/journal: no journal with seq %d", seq)
+	}
+	e := sr.index[i]
+	if _, err := sr.r.Seek(e.blockOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := sr.reader.resetForSeek(sr.r, e.chunkOffset, sr.dropper, sr.strict, sr.checksum); err != nil {
+		return nil, err
+	}
+	sr.reader.seq++
+	for {
+		if err := sr.reader.nextChunk(true); err == nil {
+			break
+		} else if err != errSkip {
+			return nil, err
+		}
+	}
+	return &singleReader{r: sr.reader, seq: sr.reader.seq}, nil
+}
+
+// Next returns a reader for the next journal after whatever was last read,
+// scanning forward exactly like Reader.Next. It works regardless of
+// whether the stream has a seekable footer, so a SeekableReader can always
+// fall back to linear iteration.
+func (sr *SeekableReader) Next() (io.Reader, error) {
+	return sr.reader.Next()
+}
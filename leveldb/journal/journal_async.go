@@ -0,0 +1,424 @@
+// Copyright 2011 The LevelDB-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package journal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// AsyncOptions configures an AsyncReader.
+type AsyncOptions struct {
+	// Strict and Checksum have the same meaning as the corresponding
+	// arguments to NewReader.
+	Strict   bool
+	Checksum bool
+	// ReadAhead is the capacity, in blocks, of each queue between the
+	// pipeline's stages (reading, verifying, reordering, consuming), so the
+	// number of blocks read ahead of the consumer can reach a few times
+	// this value. It defaults to 4 when <= 0.
+	ReadAhead int
+	// Workers is the number of goroutines verifying chunk checksums in
+	// parallel. It defaults to 2 when <= 0.
+	Workers int
+}
+
+// asyncBlock is one 32KiB block, fetched and (optionally) checksum-verified
+// off the consumer's goroutine.
+type asyncBlock struct {
+	seq int64
+	n   int
+	buf [blockSize]byte
+
+	// err is a fatal, non-EOF error from reading this block; it halts the
+	// pipeline once observed by the consumer.
+	err error
+	// badChunks holds the buffer offset (the position just past a chunk's
+	// header, i.e. the start of its payload) of every chunk in this block
+	// whose checksum failed verification. It is populated by a worker
+	// goroutine, not by the consumer, so the consumer pays no CRC cost on
+	// its own goroutine.
+	badChunks map[int]bool
+}
+
+// AsyncReader is a drop-in replacement for Reader that overlaps the
+// underlying block I/O and per-chunk CRC32C verification with the
+// consumer's processing of previously read blocks. One goroutine issues
+// io.ReadFull for successive blocks; a pool of worker goroutines verify
+// each block's chunk checksums in parallel; Next and the io.Reader it
+// returns only ever run on the caller's goroutine and never block on
+// anything but the next already-validated block.
+//
+// AsyncReader preserves the drop/skip semantics of Reader.corrupt,
+// including strict mode halting and the "orphan chunk"/"missing chunk
+// part" cases: validation outcomes are attached to each block by the
+// workers, and replayed by the consumer in stream order exactly as
+// Reader.nextChunk would have produced them synchronously.
+type AsyncReader struct {
+	dropper Dropper
+	opts    AsyncOptions
+
+	ordered   chan *asyncBlock
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// seq is the sequence number of the current journal.
+	seq int
+	// cur is the block currently being consumed; i, j index into cur.buf
+	// exactly as Reader.i, Reader.j index into Reader.buf.
+	cur  *asyncBlock
+	i, j int
+	// last is whether the current chunk is the last chunk of the journal.
+	last bool
+	// compressed is whether the current chunk belongs to a journal whose
+	// payload was compressed before being split into chunks.
+	compressed bool
+	// err is any accumulated error.
+	err error
+}
+
+// NewAsyncReader returns a new AsyncReader reading from r. The dropper may
+// be nil, exactly as with NewReader.
+func NewAsyncReader(r io.Reader, dropper Dropper, opts AsyncOptions) *AsyncReader {
+	if opts.ReadAhead <= 0 {
+		opts.ReadAhead = 4
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+
+	a := &AsyncReader{
+		dropper: dropper,
+		opts:    opts,
+		ordered: make(chan *asyncBlock, opts.ReadAhead),
+		closeCh: make(chan struct{}),
+		last:    true,
+	}
+
+	raw := make(chan *asyncBlock, opts.ReadAhead)
+	done := make(chan *asyncBlock, opts.ReadAhead)
+
+	go a.fetchLoop(r, raw)
+
+	var wg sync.WaitGroup
+	wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			a.verifyLoop(raw, done)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	go a.sequenceLoop(done)
+
+	return a
+}
+
+// fetchLoop sequentially reads blocks from r and hands them to the worker
+// pool in order. It stops after the first short read or error, which marks
+// the end of the stream (or a fatal I/O error) for the consumer.
+func (a *AsyncReader) fetchLoop(r io.Reader, raw chan<- *asyncBlock) {
+	defer close(raw)
+	var seq int64
+	for {
+		blk := &asyncBlock{seq: seq}
+		n, err := io.ReadFull(r, blk.buf[:])
+		blk.n = n
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			blk.err = err
+		}
+		select {
+		case raw <- blk:
+		case <-a.closeCh:
+			return
+		}
+		if blk.err != nil || n < blockSize {
+			return
+		}
+		seq++
+	}
+}
+
+// verifyLoop checksums every chunk of each block it receives, independent
+// of any other block, and forwards the (now annotated) block onward.
+func (a *AsyncReader) verifyLoop(raw <-chan *asyncBlock, done chan<- *asyncBlock) {
+	for blk := range raw {
+		if a.opts.Checksum && blk.err == nil {
+			blk.badChunks = verifyBlockChecksums(blk.buf[:blk.n])
+		}
+		select {
+		case done <- blk:
+		case <-a.closeCh:
+			return
+		}
+	}
+}
+
+// verifyBlockChecksums walks the chunks packed into buf and returns the set
+// of chunk payload offsets whose checksum does not match. It only inspects
+// one block in isolation; header-range and length-overflow validation,
+// which need cross-block state, are left to the consumer.
+func verifyBlockChecksums(buf []byte) map[int]bool {
+	var bad map[int]bool
+	j := 0
+	for j+headerSize <= len(buf) {
+		checksum := binary.LittleEndian.Uint32(buf[j+0 : j+4])
+		length := binary.LittleEndian.Uint16(buf[j+4 : j+6])
+		chunkType := buf[j+6]
+		if checksum == 0 && length == 0 && chunkType == 0 {
+			break
+		}
+		start := j + headerSize
+		end := start + int(length)
+		if end > len(buf) {
+			break
+		}
+		if checksum != util.NewCRC(buf[j+6:end]).Value() {
+			if bad == nil {
+				bad = make(map[int]bool)
+			}
+			bad[start] = true
+		}
+		j = end
+	}
+	return bad
+}
+
+// sequenceLoop reorders blocks completed by the worker pool, which may
+// finish out of order, back into stream order before handing them to the
+// consumer via a.ordered.
+func (a *AsyncReader) sequenceLoop(done <-chan *asyncBlock) {
+	defer close(a.ordered)
+	pending := make(map[int64]*asyncBlock)
+	var next int64
+	for blk := range done {
+		pending[blk.seq] = blk
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			select {
+			case a.ordered <- b:
+			case <-a.closeCh:
+				return
+			}
+			next++
+		}
+	}
+}
+
+// Close stops the background goroutines. It is safe to call more than
+// once, and safe to omit if the stream was read to its natural end (EOF),
+// since the pipeline goroutines exit on their own in that case.
+func (a *AsyncReader) Close() error {
+	a.closeOnce.Do(func() { close(a.closeCh) })
+	return nil
+}
+
+func (a *AsyncReader) corrupt(n int, reason string, skip bool) error {
+	if a.dropper != nil {
+		a.dropper.Drop(&ErrCorrupted{n, reason})
+	}
+	if a.opts.Strict && !skip {
+		a.err = errors.NewErrCorrupted(storage.FileDesc{}, &ErrCorrupted{n, reason})
+		return a.err
+	}
+	return errSkip
+}
+
+// nextChunk is AsyncReader's equivalent of Reader.nextChunk: it drives the
+// same state machine, but pulls pre-fetched, pre-verified blocks off
+// a.ordered instead of calling io.ReadFull and computing CRCs inline.
+func (a *AsyncReader) nextChunk(first bool) error {
+	for {
+		if a.cur != nil && a.j+headerSize <= a.cur.n {
+			checksum := binary.LittleEndian.Uint32(a.cur.buf[a.j+0 : a.j+4])
+			length := binary.LittleEndian.Uint16(a.cur.buf[a.j+4 : a.j+6])
+			ct := a.cur.buf[a.j+6]
+			unprocBlock := a.cur.n - a.j
+			if checksum == 0 && length == 0 && ct == 0 {
+				a.i, a.j = a.cur.n, a.cur.n
+				return a.corrupt(unprocBlock, "zero header", false)
+			}
+			if isIndexChunkType(ct) {
+				a.i, a.j = a.cur.n, a.cur.n
+				if first {
+					a.err = io.EOF
+					return a.err
+				}
+				return a.corrupt(unprocBlock, "missing chunk part", false)
+			}
+			if ct < fullChunkType || ct > compressedLastChunkType {
+				a.i, a.j = a.cur.n, a.cur.n
+				return a.corrupt(unprocBlock, fmt.Sprintf("invalid chunk type %#x", ct), false)
+			}
+			a.i = a.j + headerSize
+			a.j = a.i + int(length)
+			if a.j > a.cur.n {
+				a.i, a.j = a.cur.n, a.cur.n
+				return a.corrupt(unprocBlock, "chunk length overflows block", false)
+			} else if a.opts.Checksum && a.cur.badChunks[a.i] {
+				a.i, a.j = a.cur.n, a.cur.n
+				return a.corrupt(unprocBlock, "checksum mismatch", false)
+			}
+			if first && !isFullChunkType(ct) && !isFirstChunkType(ct) {
+				chunkLength := (a.j - a.i) + headerSize
+				a.i = a.j
+				return a.corrupt(chunkLength, "orphan chunk", true)
+			}
+			a.last = isFullChunkType(ct) || isLastChunkType(ct)
+			a.compressed = isCompressedChunkType(ct)
+			return nil
+		}
+
+		if a.cur != nil && a.cur.n < blockSize && a.cur.n > 0 {
+			if !first {
+				return a.corrupt(0, "missing chunk part", false)
+			}
+			a.err = io.EOF
+			return a.err
+		}
+
+		blk, ok := <-a.ordered
+		if !ok {
+			if !first {
+				return a.corrupt(0, "missing chunk part", false)
+			}
+			a.err = io.EOF
+			return a.err
+		}
+		if blk.err != nil {
+			a.err = blk.err
+			return a.err
+		}
+		if blk.n == 0 {
+			if !first {
+				return a.corrupt(0, "missing chunk part", false)
+			}
+			a.err = io.EOF
+			return a.err
+		}
+		a.cur = blk
+		a.i, a.j = 0, 0
+	}
+}
+
+// Next returns a reader for the next journal. It has the same contract as
+// Reader.Next.
+func (a *AsyncReader) Next() (io.Reader, error) {
+	a.seq++
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.i = a.j
+	for {
+		if err := a.nextChunk(true); err == nil {
+			break
+		} else if err != errSkip {
+			return nil, err
+		}
+	}
+	return &asyncSingleReader{a: a, seq: a.seq}, nil
+}
+
+type asyncSingleReader struct {
+	a   *AsyncReader
+	seq int
+	err error
+
+	// decoded and decodedPos serve reads for a compressed journal, once
+	// loadCompressed has buffered and decompressed all of its chunks. They
+	// mirror singleReader's fields of the same name.
+	loaded     bool
+	decoded    []byte
+	decodedPos int
+}
+
+// loadCompressed concatenates every remaining chunk of the current journal
+// into a single buffer and decompresses it, mirroring singleReader's method
+// of the same name.
+func (x *asyncSingleReader) loadCompressed() error {
+	a := x.a
+	var raw []byte
+	for {
+		raw = append(raw, a.cur.buf[a.i:a.j]...)
+		a.i = a.j
+		if a.last {
+			break
+		}
+		if err := a.nextChunk(false); err != nil {
+			if err == errSkip {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	decoded, err := snappy.Decode(nil, raw)
+	if err != nil {
+		err = a.corrupt(len(raw), "corrupted compressed payload", false)
+		if err == errSkip {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	x.decoded = decoded
+	x.loaded = true
+	return nil
+}
+
+func (x *asyncSingleReader) Read(p []byte) (int, error) {
+	a := x.a
+	if a.seq != x.seq {
+		return 0, errors.New("leveldb/journal: stale reader")
+	}
+	if x.err != nil {
+		return 0, x.err
+	}
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.compressed {
+		if !x.loaded {
+			if err := x.loadCompressed(); err != nil {
+				x.err = err
+				return 0, err
+			}
+		}
+		if x.decodedPos == len(x.decoded) {
+			return 0, io.EOF
+		}
+		n := copy(p, x.decoded[x.decodedPos:])
+		x.decodedPos += n
+		return n, nil
+	}
+	for a.i == a.j {
+		if a.last {
+			return 0, io.EOF
+		}
+		x.err = a.nextChunk(false)
+		if x.err != nil {
+			if x.err == errSkip {
+				x.err = io.ErrUnexpectedEOF
+			}
+			return 0, x.err
+		}
+	}
+	n := copy(p, a.cur.buf[a.i:a.j])
+	a.i += n
+	return n, nil
+}